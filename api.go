@@ -0,0 +1,178 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "encoding/json"
+    "google.golang.org/appengine"
+    "log"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var teamTitleRegexp *regexp.Regexp
+
+// apiMember is the wire shape for /api/members: the canonical User plus
+// its derived team, so the browser doesn't need to duplicate the regex
+// used to group people by department.
+type apiMember struct {
+    User
+    Team string `json:"team"`
+}
+
+// teamForUser derives a department/team name from a user's title using
+// cfg.TeamTitleRegex. Titles that don't match fall into "Other" rather
+// than being dropped from the grouping entirely.
+func teamForUser(u User) string {
+    if teamTitleRegexp == nil {
+        return "Other"
+    }
+    m := teamTitleRegexp.FindStringSubmatch(u.Profile.Title)
+    if len(m) < 2 || strings.TrimSpace(m[1]) == "" {
+        return "Other"
+    }
+    return strings.TrimSpace(m[1])
+}
+
+func containsFold(haystack, needle string) bool {
+    return strings.Contains(strings.ToLower(haystack), strings.ToLower(needle))
+}
+
+// filterMembers applies the q/title/team/status query params to members.
+// q matches across RealName, Title, Email and Status; the rest match
+// their single named field. All are substring, case-insensitive.
+func filterMembers(members []User, q, title, team, status string) []apiMember {
+    filtered := make([]apiMember, 0, len(members))
+    for _, u := range members {
+        if q != "" &&
+            !containsFold(u.Profile.RealName, q) &&
+            !containsFold(u.Profile.Title, q) &&
+            !containsFold(u.Profile.Email, q) &&
+            !containsFold(u.Profile.Status, q) {
+            continue
+        }
+        if title != "" && !containsFold(u.Profile.Title, title) {
+            continue
+        }
+        if status != "" && !containsFold(u.Profile.Status, status) {
+            continue
+        }
+        memberTeam := teamForUser(u)
+        if team != "" && !containsFold(memberTeam, team) {
+            continue
+        }
+        filtered = append(filtered, apiMember{User: u, Team: memberTeam})
+    }
+    return filtered
+}
+
+func membersHandler(w http.ResponseWriter, r *http.Request) {
+    userlist, _, _ := directory.snapshot()
+    q := r.URL.Query()
+    members := filterMembers(userlist.Members, q.Get("q"), q.Get("title"), q.Get("team"), q.Get("status"))
+
+    w.Header().Set("Content-Type", "application/json")
+    resp := struct {
+        Members []apiMember `json:"members"`
+    }{Members: members}
+    if err := json.NewEncoder(w).Encode(resp); err != nil {
+        log.Printf("Failed to encode members response: %v\n", err)
+    }
+}
+
+// profileFieldsHandler returns the label for each configured
+// PROFILE_FIELDS entry, in the order they were configured, so the
+// browser can render "Department: Platform" instead of a raw field ID.
+func profileFieldsHandler(w http.ResponseWriter, r *http.Request) {
+    labels := directory.profileFieldLabels()
+
+    type profileField struct {
+        Id    string `json:"id"`
+        Label string `json:"label"`
+    }
+    fields := make([]profileField, 0, len(cfg.ProfileFields))
+    for _, id := range cfg.ProfileFields {
+        label := labels[id]
+        if label == "" {
+            label = id
+        }
+        fields = append(fields, profileField{Id: id, Label: label})
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    resp := struct {
+        Fields []profileField `json:"fields"`
+    }{Fields: fields}
+    if err := json.NewEncoder(w).Encode(resp); err != nil {
+        log.Printf("Failed to encode profile-fields response: %v\n", err)
+    }
+}
+
+// eventsHandler returns directory change events that happened after the
+// Unix timestamp in ?since=, so integrations can poll incrementally.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+    since := time.Unix(0, 0)
+    if raw := r.URL.Query().Get("since"); raw != "" {
+        secs, err := strconv.ParseInt(raw, 10, 64)
+        if err != nil {
+            http.Error(w, "invalid since parameter", http.StatusBadRequest)
+            return
+        }
+        since = time.Unix(secs, 0)
+    }
+
+    events, err := eventStore.Events(appengine.NewContext(r), since)
+    if err != nil {
+        log.Printf("Failed to load events: %v\n", err)
+        http.Error(w, "failed to load events", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    resp := struct {
+        Events []Event `json:"events"`
+    }{Events: events}
+    if err := json.NewEncoder(w).Encode(resp); err != nil {
+        log.Printf("Failed to encode events response: %v\n", err)
+    }
+}
+
+func teamsHandler(w http.ResponseWriter, r *http.Request) {
+    userlist, _, _ := directory.snapshot()
+    seen := map[string]bool{}
+    var teams []string
+    for _, u := range userlist.Members {
+        t := teamForUser(u)
+        if !seen[t] {
+            seen[t] = true
+            teams = append(teams, t)
+        }
+    }
+    sort.Strings(teams)
+
+    w.Header().Set("Content-Type", "application/json")
+    resp := struct {
+        Teams []string `json:"teams"`
+    }{Teams: teams}
+    if err := json.NewEncoder(w).Encode(resp); err != nil {
+        log.Printf("Failed to encode teams response: %v\n", err)
+    }
+}
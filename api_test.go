@@ -0,0 +1,59 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "regexp"
+    "testing"
+)
+
+var filterTestMembers = []User{
+    {Id: "u1", Profile: Profile{RealName: "Ada Lovelace", Title: "Engineer, Platform", Email: "ada@example.com"}},
+    {Id: "u2", Profile: Profile{RealName: "Grace Hopper", Title: "Engineer, Infra", Email: "grace@example.com", Status: "Out sick"}},
+}
+
+func TestFilterMembersByQ(t *testing.T) {
+    got := filterMembers(filterTestMembers, "grace", "", "", "")
+    if len(got) != 1 || got[0].Id != "u2" {
+        t.Fatalf("expected only u2 to match q=grace, got %+v", got)
+    }
+}
+
+func TestFilterMembersByTeam(t *testing.T) {
+    previous := teamTitleRegexp
+    teamTitleRegexp = regexp.MustCompile(`.*,\s*(.+)$`)
+    defer func() { teamTitleRegexp = previous }()
+
+    got := filterMembers(filterTestMembers, "", "", "Platform", "")
+    if len(got) != 1 || got[0].Id != "u1" {
+        t.Fatalf("expected only u1 to match team=Platform, got %+v", got)
+    }
+}
+
+func TestFilterMembersByStatus(t *testing.T) {
+    got := filterMembers(filterTestMembers, "", "", "", "sick")
+    if len(got) != 1 || got[0].Id != "u2" {
+        t.Fatalf("expected only u2 to match status=sick, got %+v", got)
+    }
+}
+
+func TestFilterMembersNoFilters(t *testing.T) {
+    got := filterMembers(filterTestMembers, "", "", "", "")
+    if len(got) != len(filterTestMembers) {
+        t.Fatalf("expected all members with no filters, got %d", len(got))
+    }
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "google.golang.org/appengine"
+    "google.golang.org/appengine/urlfetch"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+const (
+    sessionCookieName   = "facelist_session"
+    oauthStateCookie    = "facelist_oauth_state"
+    slackAuthorizeURL   = "https://slack.com/oauth/authorize"
+    slackOAuthAccessURL = "https://slack.com/api/oauth.access"
+)
+
+// session is the signed, cookie-stored identity of a logged in user.
+type session struct {
+    UserID    string    `json:"user_id"`
+    Email     string    `json:"email"`
+    TeamID    string    `json:"team_id"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s session) expired() bool {
+    return time.Now().After(s.ExpiresAt)
+}
+
+// signSession serializes and HMAC-signs a session so it can be stored
+// client-side in a cookie without letting the client forge or alter it.
+func signSession(s session) (string, error) {
+    payload, err := json.Marshal(s)
+    if err != nil {
+        return "", err
+    }
+    encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+    return encodedPayload + "." + sign(encodedPayload), nil
+}
+
+func verifySession(cookieValue string) (session, bool) {
+    parts := strings.SplitN(cookieValue, ".", 2)
+    if len(parts) != 2 {
+        return session{}, false
+    }
+    encodedPayload, sig := parts[0], parts[1]
+    if subtle.ConstantTimeCompare([]byte(sig), []byte(sign(encodedPayload))) != 1 {
+        return session{}, false
+    }
+
+    payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+    if err != nil {
+        return session{}, false
+    }
+    var s session
+    if err := json.Unmarshal(payload, &s); err != nil {
+        return session{}, false
+    }
+    if s.expired() {
+        return session{}, false
+    }
+    return s, true
+}
+
+func sign(data string) string {
+    mac := hmac.New(sha256.New, []byte(cfg.SessionSecret))
+    mac.Write([]byte(data))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// requireAuth redirects to the login flow unless the request carries a
+// valid, unexpired session cookie. It's for pages and APIs that are only
+// ever called from a signed-in browser.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        cookie, err := r.Cookie(sessionCookieName)
+        if err != nil {
+            http.Redirect(w, r, "/oauth/login", http.StatusFound)
+            return
+        }
+        if _, ok := verifySession(cookie.Value); !ok {
+            http.Redirect(w, r, "/oauth/login", http.StatusFound)
+            return
+        }
+        next(w, r)
+    }
+}
+
+// requireSessionOrEventsToken accepts either a valid session cookie (for
+// browsing /api/events from the same signed-in browser) or the shared
+// EVENTS_API_TOKEN as a bearer token (for webhook consumers and
+// onboarding scripts, which can never complete the interactive OAuth
+// flow). Unlike requireAuth, failure is a 401, not a redirect, since the
+// caller is assumed to be a non-browser integration as often as not.
+func requireSessionOrEventsToken(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if token := bearerToken(r); token != "" && cfg.EventsAPIToken != "" &&
+            subtle.ConstantTimeCompare([]byte(token), []byte(cfg.EventsAPIToken)) == 1 {
+            next(w, r)
+            return
+        }
+        if cookie, err := r.Cookie(sessionCookieName); err == nil {
+            if _, ok := verifySession(cookie.Value); ok {
+                next(w, r)
+                return
+            }
+        }
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+    }
+}
+
+func bearerToken(r *http.Request) string {
+    const prefix = "Bearer "
+    auth := r.Header.Get("Authorization")
+    if !strings.HasPrefix(auth, prefix) {
+        return ""
+    }
+    return strings.TrimPrefix(auth, prefix)
+}
+
+func oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+    state, err := randomState()
+    if err != nil {
+        http.Error(w, "failed to start login", http.StatusInternalServerError)
+        return
+    }
+    http.SetCookie(w, &http.Cookie{
+        Name:     oauthStateCookie,
+        Value:    state,
+        Path:     "/",
+        HttpOnly: true,
+        Secure:   isRequestSecure(r),
+        SameSite: http.SameSiteLaxMode,
+        MaxAge:   300,
+    })
+
+    q := url.Values{}
+    q.Set("client_id", cfg.SlackClientID)
+    q.Set("scope", "identify")
+    q.Set("redirect_uri", oauthRedirectURL(r))
+    q.Set("state", state)
+    http.Redirect(w, r, slackAuthorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+    stateCookie, err := r.Cookie(oauthStateCookie)
+    if err != nil || stateCookie.Value == "" || stateCookie.Value != r.URL.Query().Get("state") {
+        http.Error(w, "invalid oauth state", http.StatusBadRequest)
+        return
+    }
+
+    identity, err := exchangeSlackCode(appengine.NewContext(r), r.URL.Query().Get("code"), oauthRedirectURL(r))
+    if err != nil {
+        log.Printf("facelist: oauth exchange failed: %v\n", err)
+        http.Error(w, "login failed", http.StatusUnauthorized)
+        return
+    }
+    if identity.Team.Id != cfg.SlackTeam {
+        http.Error(w, "wrong Slack team", http.StatusForbidden)
+        return
+    }
+    if !isAllowed(identity.User.Email) {
+        http.Error(w, "you are not allowed to access this directory", http.StatusForbidden)
+        return
+    }
+
+    s := session{
+        UserID:    identity.User.Id,
+        Email:     identity.User.Email,
+        TeamID:    identity.Team.Id,
+        ExpiresAt: time.Now().Add(cfg.SessionDuration),
+    }
+    signed, err := signSession(s)
+    if err != nil {
+        http.Error(w, "login failed", http.StatusInternalServerError)
+        return
+    }
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookieName,
+        Value:    signed,
+        Path:     "/",
+        HttpOnly: true,
+        Secure:   isRequestSecure(r),
+        SameSite: http.SameSiteLaxMode,
+        Expires:  s.ExpiresAt,
+    })
+    http.SetCookie(w, &http.Cookie{
+        Name:     oauthStateCookie,
+        Value:    "",
+        Path:     "/",
+        Secure:   isRequestSecure(r),
+        SameSite: http.SameSiteLaxMode,
+        MaxAge:   -1,
+    })
+
+    http.Redirect(w, r, "/", http.StatusFound)
+}
+
+type slackOAuthIdentity struct {
+    Ok    bool   `json:"ok"`
+    Error string `json:"error"`
+    User  struct {
+        Id    string `json:"id"`
+        Name  string `json:"name"`
+        Email string `json:"email"`
+    } `json:"user"`
+    Team struct {
+        Id string `json:"id"`
+    } `json:"team"`
+}
+
+func exchangeSlackCode(ctx context.Context, code, redirectURI string) (*slackOAuthIdentity, error) {
+    client := urlfetch.Client(ctx)
+    form := url.Values{}
+    form.Set("client_id", cfg.SlackClientID)
+    form.Set("client_secret", cfg.SlackClientSecret)
+    form.Set("code", code)
+    form.Set("redirect_uri", redirectURI)
+
+    resp, err := client.PostForm(slackOAuthAccessURL, form)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    var identity slackOAuthIdentity
+    if err := json.Unmarshal(body, &identity); err != nil {
+        return nil, err
+    }
+    if !identity.Ok {
+        return nil, fmt.Errorf("slack: oauth.access failed: %s", identity.Error)
+    }
+    return &identity, nil
+}
+
+// isAllowed checks email against the configured allowlist. An empty
+// allowlist means every member of the Slack team is allowed in.
+func isAllowed(email string) bool {
+    if len(cfg.AllowedEmails) == 0 {
+        return true
+    }
+    for _, allowed := range cfg.AllowedEmails {
+        if strings.EqualFold(strings.TrimSpace(allowed), email) {
+            return true
+        }
+    }
+    return false
+}
+
+func isRequestSecure(r *http.Request) bool {
+    return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func oauthRedirectURL(r *http.Request) string {
+    scheme := "http"
+    if isRequestSecure(r) {
+        scheme = "https"
+    }
+    return scheme + "://" + r.Host + "/oauth/callback"
+}
+
+func randomState() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
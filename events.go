@@ -0,0 +1,160 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "strings"
+    "time"
+)
+
+// EventType identifies the kind of directory change an Event describes.
+type EventType string
+
+const (
+    EventUserJoined       EventType = "user.joined"
+    EventUserLeft         EventType = "user.left"
+    EventUserTitleChanged EventType = "user.title_changed"
+    EventUserPhotoChanged EventType = "user.photo_changed"
+)
+
+// Event is a single directory change, as produced by diffing two
+// consecutive syncs.
+type Event struct {
+    Type      EventType `json:"type"`
+    Timestamp time.Time `json:"timestamp"`
+    UserID    string    `json:"user_id"`
+    Email     string    `json:"email"`
+    Name      string    `json:"name"`
+    OldValue  string    `json:"old_value,omitempty"`
+    NewValue  string    `json:"new_value,omitempty"`
+}
+
+// diffUsers compares a previous and current snapshot of the directory and
+// returns the events that describe what changed, keyed on user ID.
+func diffUsers(previous, current []User, at time.Time) []Event {
+    previousByID := make(map[string]User, len(previous))
+    for _, u := range previous {
+        previousByID[u.Id] = u
+    }
+    currentByID := make(map[string]User, len(current))
+    for _, u := range current {
+        currentByID[u.Id] = u
+    }
+
+    var events []Event
+    for id, u := range currentByID {
+        old, existed := previousByID[id]
+        if !existed {
+            events = append(events, Event{
+                Type: EventUserJoined, Timestamp: at,
+                UserID: id, Email: u.Profile.Email, Name: u.Profile.RealName,
+            })
+            continue
+        }
+        if old.Profile.Title != u.Profile.Title {
+            events = append(events, Event{
+                Type: EventUserTitleChanged, Timestamp: at,
+                UserID: id, Email: u.Profile.Email, Name: u.Profile.RealName,
+                OldValue: old.Profile.Title, NewValue: u.Profile.Title,
+            })
+        }
+        if old.Profile.Image192 != u.Profile.Image192 {
+            events = append(events, Event{
+                Type: EventUserPhotoChanged, Timestamp: at,
+                UserID: id, Email: u.Profile.Email, Name: u.Profile.RealName,
+                OldValue: old.Profile.Image192, NewValue: u.Profile.Image192,
+            })
+        }
+    }
+    for id, u := range previousByID {
+        if _, stillHere := currentByID[id]; !stillHere {
+            events = append(events, Event{
+                Type: EventUserLeft, Timestamp: at,
+                UserID: id, Email: u.Profile.Email, Name: u.Profile.RealName,
+            })
+        }
+    }
+
+    return events
+}
+
+// processDirectoryChanges diffs current against the last snapshot saved in
+// store, persists the new snapshot and any resulting events, and fans the
+// events out to every configured sink. Sink failures are logged by the
+// caller and never block the sync.
+func processDirectoryChanges(ctx context.Context, store Store, sinks []EventSink, current []User, at time.Time) ([]Event, []error) {
+    var errs []error
+
+    previous, found, err := store.LastSnapshot(ctx)
+    if err != nil {
+        errs = append(errs, err)
+    }
+
+    // No snapshot has ever been saved (first sync after deploy, or any
+    // sync after a memory-store restart): seed the baseline silently
+    // instead of emitting a user.joined event per existing employee.
+    var events []Event
+    if found {
+        events = diffUsers(previous, current, at)
+    }
+
+    if err := store.SaveSnapshot(ctx, current); err != nil {
+        errs = append(errs, err)
+    }
+    if len(events) > 0 {
+        if err := store.AppendEvents(ctx, events); err != nil {
+            errs = append(errs, err)
+        }
+    }
+
+    for _, event := range events {
+        for _, sink := range sinks {
+            if err := sink.Send(ctx, event); err != nil {
+                errs = append(errs, err)
+            }
+        }
+    }
+
+    return events, errs
+}
+
+// buildEventSinks turns the configured EVENT_SINKS names into EventSinks.
+func buildEventSinks(cfg config) []EventSink {
+    var sinks []EventSink
+    for _, name := range cfg.EventSinks {
+        switch strings.ToLower(strings.TrimSpace(name)) {
+        case "slack_webhook":
+            sinks = append(sinks, &SlackWebhookSink{
+                URL:      cfg.SlackWebhookURL,
+                Username: cfg.SlackWebhookUsername,
+                IconEmoji: cfg.SlackWebhookIconEmoji,
+            })
+        case "https":
+            sinks = append(sinks, &HTTPSWebhookSink{
+                URL:    cfg.WebhookURL,
+                Secret: cfg.WebhookSecret,
+            })
+        case "slack_chat":
+            sinks = append(sinks, &SlackChatSink{
+                APIToken: cfg.SlackApiToken,
+                Channel:  cfg.SlackEventsChannel,
+            })
+        }
+    }
+    return sinks
+}
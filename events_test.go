@@ -0,0 +1,80 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func eventTypes(events []Event) map[EventType]int {
+    counts := map[EventType]int{}
+    for _, e := range events {
+        counts[e.Type]++
+    }
+    return counts
+}
+
+func TestDiffUsersJoinedAndLeft(t *testing.T) {
+    at := time.Unix(0, 0)
+    previous := []User{
+        {Id: "u1", Profile: Profile{Email: "a@example.com", RealName: "A"}},
+    }
+    current := []User{
+        {Id: "u2", Profile: Profile{Email: "b@example.com", RealName: "B"}},
+    }
+
+    events := diffUsers(previous, current, at)
+    counts := eventTypes(events)
+    if counts[EventUserJoined] != 1 {
+        t.Errorf("expected 1 user.joined event, got %d", counts[EventUserJoined])
+    }
+    if counts[EventUserLeft] != 1 {
+        t.Errorf("expected 1 user.left event, got %d", counts[EventUserLeft])
+    }
+}
+
+func TestDiffUsersTitleAndPhotoChanged(t *testing.T) {
+    at := time.Unix(0, 0)
+    previous := []User{
+        {Id: "u1", Profile: Profile{Email: "a@example.com", Title: "Engineer", Image192: "old.png"}},
+    }
+    current := []User{
+        {Id: "u1", Profile: Profile{Email: "a@example.com", Title: "Manager", Image192: "new.png"}},
+    }
+
+    events := diffUsers(previous, current, at)
+    counts := eventTypes(events)
+    if counts[EventUserTitleChanged] != 1 {
+        t.Errorf("expected 1 user.title_changed event, got %d", counts[EventUserTitleChanged])
+    }
+    if counts[EventUserPhotoChanged] != 1 {
+        t.Errorf("expected 1 user.photo_changed event, got %d", counts[EventUserPhotoChanged])
+    }
+}
+
+func TestDiffUsersNoChanges(t *testing.T) {
+    at := time.Unix(0, 0)
+    users := []User{
+        {Id: "u1", Profile: Profile{Email: "a@example.com", Title: "Engineer", Image192: "same.png"}},
+    }
+
+    events := diffUsers(users, users, at)
+    if len(events) != 0 {
+        t.Errorf("expected no events for an unchanged snapshot, got %d", len(events))
+    }
+}
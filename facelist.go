@@ -17,18 +17,20 @@ limitations under the License.
 package main
 
 import (
+    "context"
     "encoding/json"
-    "fmt"
     "github.com/kelseyhightower/envconfig"
     "google.golang.org/appengine"
     "google.golang.org/appengine/urlfetch"
     "html/template"
-    "io/ioutil"
     "log"
     "net/http"
     "os"
+    "regexp"
     "sort"
     "strings"
+    "sync"
+    "time"
 )
 
 const (
@@ -66,52 +68,149 @@ const (
         .title {
             color: gray;
         }
+        .field {
+            font-size: 12px;
+            color: gray;
+        }
+        .field-label {
+            font-weight: bold;
+        }
         .user {
             padding: 10px;
         }
+        .team-header {
+            grid-column: 1 / -1;
+            font-size: 20px;
+            border-bottom: 1px solid #ddd;
+            margin-top: 10px;
+        }
     </style>
     <script>
+    var profileFields = [];
+
     window.onload = function() {
       document.getElementById("searchField").focus();
+      fetch('/api/profile-fields')
+        .then(function(resp) { return resp.json(); })
+        .then(function(data) { profileFields = data.fields || []; })
+        .catch(function(err) { console.error('facelist: failed to fetch profile fields', err); })
+        .then(render);
     };
-    function search() {
-      // Declare variables
-      var input, filter, container, users, a, i, txtValue;
-      input = document.getElementById('searchField');
-      filter = input.value.toUpperCase();
-      container = document.getElementById("container");
-      users = container.getElementsByClassName('user');
-
-      // Loop through all users, and hide those who don't match the search query
-      for (i = 0; i < users.length; i++) {
-        a = users[i].getElementsByClassName("name")[0];
-        txtValue = a.textContent || a.innerText;
-        if (txtValue.toUpperCase().indexOf(filter) > -1) {
-          users[i].style.display = "";
-        } else {
-          users[i].style.display = "none";
+
+    function debounce(fn, delay) {
+      var timer;
+      return function() {
+        clearTimeout(timer);
+        timer = setTimeout(fn, delay);
+      };
+    }
+
+    var debouncedRender = debounce(render, 200);
+
+    function render() {
+      var q = document.getElementById('searchField').value;
+      var url = '/api/members';
+      if (q) {
+        url += '?q=' + encodeURIComponent(q);
+      }
+      fetch(url)
+        .then(function(resp) { return resp.json(); })
+        .then(function(data) { renderMembers(data.members || []); })
+        .catch(function(err) { console.error('facelist: failed to fetch members', err); });
+    }
+
+    function renderMembers(members) {
+      var groups = {};
+      members.forEach(function(m) {
+        var team = m.team || 'Other';
+        (groups[team] = groups[team] || []).push(m);
+      });
+
+      var teamNames = Object.keys(groups).sort();
+      var container = document.getElementById('container');
+      container.innerHTML = '';
+
+      teamNames.forEach(function(team) {
+        var header = document.createElement('div');
+        header.className = 'team-header';
+        header.textContent = team;
+        container.appendChild(header);
+
+        groups[team].forEach(function(m) {
+          container.appendChild(renderMember(m));
+        });
+      });
+    }
+
+    function renderMember(m) {
+      var profile = m.profile || {};
+      var name = profile.real_name || m.name;
+      var statusEmoji = profile.status_emoji ? profile.status_emoji + ' ' : '';
+      var srcset = [192, 512, 1024].map(function(size) {
+        var src = profile['image_' + size];
+        return src ? src + ' ' + size + 'w' : null;
+      }).filter(Boolean).join(', ');
+      var slackLink = 'slack://user?team=' + encodeURIComponent(m.team_id || '') + '&id=' + encodeURIComponent(m.id || '');
+
+      // Built with createElement/textContent rather than innerHTML:
+      // every string below (real_name, title, status_emoji, custom
+      // field values) is edited by the directory member it belongs to,
+      // so it must never be parsed as HTML.
+      var nameDiv = document.createElement('div');
+      nameDiv.className = 'name';
+      nameDiv.appendChild(document.createTextNode(statusEmoji + name + ' '));
+      var nameLink = document.createElement('a');
+      nameLink.href = slackLink;
+      var badge = document.createElement('img');
+      badge.src = 'https://a.slack-edge.com/436da/marketing/img/meta/favicon-32.png';
+      badge.title = 'Contact ' + name + ' on Slack';
+      badge.width = 16;
+      badge.height = 16;
+      nameLink.appendChild(badge);
+      nameDiv.appendChild(nameLink);
+
+      var titleDiv = document.createElement('div');
+      titleDiv.className = 'title';
+      titleDiv.appendChild(document.createTextNode((profile.title || '') + ' '));
+
+      var div = document.createElement('div');
+      div.className = 'user';
+      div.appendChild(nameDiv);
+      div.appendChild(titleDiv);
+
+      profileFields.forEach(function(f) {
+        var field = profile.fields && profile.fields[f.id];
+        if (!field || !field.value) {
+          return;
         }
+        var fieldDiv = document.createElement('div');
+        fieldDiv.className = 'field';
+        var label = document.createElement('span');
+        label.className = 'field-label';
+        label.textContent = f.label + ':';
+        fieldDiv.appendChild(label);
+        fieldDiv.appendChild(document.createTextNode(' ' + field.value));
+        div.appendChild(fieldDiv);
+      });
+
+      var photoLink = document.createElement('a');
+      photoLink.href = slackLink;
+      var photo = document.createElement('img');
+      photo.src = profile.image_192 || '';
+      if (srcset) {
+        photo.srcset = srcset;
       }
+      photo.title = 'Contact ' + name + ' on Slack';
+      photoLink.appendChild(photo);
+      div.appendChild(photoLink);
+
+      return div;
     }
     </script>
   </head>
   <body>
-    <input type="text" id="searchField" onkeyup="search()" placeholder="Search by name...">
-    <div id="container">
-    {{range .Members}}
-        <div class="user">
-            <div class="name">{{if .Profile.RealName}}{{.Profile.RealName}}{{else}}{{.Name}}{{end}}
-            <a href="slack://user?team={{.TeamId}}&id={{.Id}}">
-                <img src="https://a.slack-edge.com/436da/marketing/img/meta/favicon-32.png" title="Contact {{.Profile.FirstName}} on Slack" width="16" height="16"/>
-            </a>
-            </div>
-            <div class="title">{{.Profile.Title}}&nbsp;</div>
-            <a href="slack://user?team={{.TeamId}}&id={{.Id}}">
-                <img src="{{.Profile.Image}}" title="Contact {{.Profile.FirstName}} on Slack"/>
-            </a>
-        </div>
-    {{end}}
-    </div>
+    <input type="text" id="searchField" onkeyup="debouncedRender()" placeholder="Search by name, title or email...">
+    <div id="container"></div>
     <hr>
     {{ len .Members }} faces served by <a href="https://github.com/tink-ab/facelist">https://github.com/tink-ab/facelist</a>
   </body>
@@ -121,20 +220,94 @@ const (
 
 var (
     cfg           config
-    userlist      UserList
     IndexTemplate = template.Must(template.New("index").Parse(IndexTmpl))
+
+    directory  = &directoryCache{}
+    eventStore Store
+    eventSinks []EventSink
 )
 
 type (
     config struct {
-        EmailFilter   string `envconfig:"EMAIL_FILTER" default:""`
-        SlackApiToken string `envconfig:"SLACK_API_TOKEN"`
-        SlackTeam     string `envconfig:"SLACK_TEAM"`
+        EmailFilter   string        `envconfig:"EMAIL_FILTER" default:""`
+        SlackApiToken string        `envconfig:"SLACK_API_TOKEN"`
+        SlackTeam     string        `envconfig:"SLACK_TEAM"`
+        SyncInterval  time.Duration `envconfig:"SYNC_INTERVAL" default:"5m"`
+
+        // Providers lists the directory backends to query, merged by
+        // email. Defaults to Slack alone so existing deployments keep
+        // working unchanged.
+        Providers []string `envconfig:"PROVIDERS" default:"slack"`
+
+        GoogleAPIToken string `envconfig:"GOOGLE_API_TOKEN"`
+        GoogleDomain   string `envconfig:"GOOGLE_DOMAIN"`
+
+        MSGraphAPIToken string `envconfig:"MSGRAPH_API_TOKEN"`
+
+        LDAPURL      string `envconfig:"LDAP_URL"`
+        LDAPBindDN   string `envconfig:"LDAP_BIND_DN"`
+        LDAPBindPass string `envconfig:"LDAP_BIND_PASS"`
+        LDAPBaseDN   string `envconfig:"LDAP_BASE_DN"`
+        LDAPFilter   string `envconfig:"LDAP_FILTER" default:"(objectClass=person)"`
+
+        // TeamTitleRegex extracts a team/department name from a user's
+        // title for grouping in /api/teams and the index page; its last
+        // submatch is used as the team name. The default expects titles
+        // like "Engineer, Platform".
+        TeamTitleRegex string `envconfig:"TEAM_TITLE_REGEX" default:".*,\\s*(.+)$"`
+
+        SlackClientID     string        `envconfig:"SLACK_CLIENT_ID"`
+        SlackClientSecret string        `envconfig:"SLACK_CLIENT_SECRET"`
+        SessionSecret     string        `envconfig:"SESSION_SECRET"`
+        SessionDuration   time.Duration `envconfig:"SESSION_DURATION" default:"168h"`
+
+        // AllowedEmails optionally restricts login to specific addresses;
+        // an empty list allows every member of SlackTeam.
+        AllowedEmails []string `envconfig:"ALLOWED_EMAILS"`
+
+        // ProfileFields lists the Slack custom profile field IDs to show
+        // on each user's card, e.g. "Xf0A1B2C3D,Xf0E4F5G6H".
+        ProfileFields []string `envconfig:"PROFILE_FIELDS"`
+
+        // EventStore selects where the last-seen snapshot and change
+        // events are persisted: "datastore" (durable, App Engine) or
+        // "memory" (lost on restart, local development).
+        EventStore string `envconfig:"EVENT_STORE" default:"memory"`
+
+        // EventSinks lists which sinks receive directory change events:
+        // "slack_webhook", "https", "slack_chat".
+        EventSinks []string `envconfig:"EVENT_SINKS"`
+
+        SlackWebhookURL       string `envconfig:"SLACK_WEBHOOK_URL"`
+        SlackWebhookUsername  string `envconfig:"SLACK_WEBHOOK_USERNAME" default:"facelist"`
+        SlackWebhookIconEmoji string `envconfig:"SLACK_WEBHOOK_ICON_EMOJI" default:":bust_in_silhouette:"`
+
+        SlackEventsChannel string `envconfig:"SLACK_EVENTS_CHANNEL"`
+
+        WebhookURL    string `envconfig:"WEBHOOK_URL"`
+        WebhookSecret string `envconfig:"WEBHOOK_SECRET"`
+
+        // EventsAPIToken is a shared secret integrations present as
+        // "Authorization: Bearer <token>" to read /api/events, since
+        // they can't complete the interactive Slack OAuth login that
+        // gates the rest of the site.
+        EventsAPIToken string `envconfig:"EVENTS_API_TOKEN"`
+    }
+
+    // directoryCache holds the most recently synced member list along with
+    // bookkeeping about the sync itself, so indexHandler never has to wait
+    // on Slack while serving a request.
+    directoryCache struct {
+        mu           sync.RWMutex
+        userlist     UserList
+        fieldLabels  map[string]string
+        lastSyncTime time.Time
+        lastSyncErr  error
     }
 
     UserList struct {
         SlackTeam string
-        Members   []User `json:members`
+        Members   []User `json:"members"`
     }
 
     User struct {
@@ -147,14 +320,38 @@ type (
     }
 
     Profile struct {
-        FirstName string `json:"first_name"`
-        LastName  string `json:"last_name"`
-        RealName  string `json:"real_name"`
-        Title     string `json:"title"`
-        Image     string `json:"image_192"`
-        Phone     string `json:"phone"`
-        Email     string `json:"email"`
-        Status    string `json:"status_text"`
+        FirstName             string `json:"first_name"`
+        LastName              string `json:"last_name"`
+        RealName              string `json:"real_name"`
+        RealNameNormalized    string `json:"real_name_normalized"`
+        DisplayName           string `json:"display_name"`
+        DisplayNameNormalized string `json:"display_name_normalized"`
+        Title                 string `json:"title"`
+        Phone                 string `json:"phone"`
+        Email                 string `json:"email"`
+        Status                string `json:"status_text"`
+        StatusEmoji           string `json:"status_emoji"`
+        StatusExpiration      int64  `json:"status_expiration"`
+        Image24               string `json:"image_24"`
+        Image32               string `json:"image_32"`
+        Image48               string `json:"image_48"`
+        Image72               string `json:"image_72"`
+        Image192              string `json:"image_192"`
+        Image512              string `json:"image_512"`
+        Image1024             string `json:"image_1024"`
+        ImageOriginal         string `json:"image_original"`
+        Tz                    string `json:"tz"`
+        TzLabel               string `json:"tz_label"`
+
+        // Fields holds Slack's custom profile fields, keyed by field ID.
+        // Only the IDs listed in PROFILE_FIELDS are surfaced in the UI.
+        Fields map[string]CustomField `json:"fields"`
+    }
+
+    // CustomField is one entry from Slack's custom profile fields.
+    CustomField struct {
+        Value string `json:"value"`
+        Alt   string `json:"alt"`
     }
 )
 
@@ -167,34 +364,47 @@ func init() {
         log.Fatalf("SLACK_TEAM is not set!")
         os.Exit(1)
     }
-    if cfg.SlackApiToken == "" {
+    if cfg.SlackApiToken == "" && hasProvider(cfg, "slack") {
         log.Fatalf("SLACK_API_TOKEN is not set!")
         os.Exit(1)
     }
-    userlist.SlackTeam = cfg.SlackTeam
+    if cfg.TeamTitleRegex != "" {
+        teamTitleRegexp = regexp.MustCompile(cfg.TeamTitleRegex)
+    }
+    if cfg.SlackClientID == "" || cfg.SlackClientSecret == "" {
+        log.Fatalf("SLACK_CLIENT_ID and SLACK_CLIENT_SECRET must be set!")
+    }
+    if cfg.SessionSecret == "" {
+        log.Fatalf("SESSION_SECRET must be set!")
+    }
+    eventStore = newStore(cfg)
+    eventSinks = buildEventSinks(cfg)
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-
-    ctx := appengine.NewContext(r)
-    client := urlfetch.Client(ctx)
-    url := fmt.Sprintf("https://slack.com/api/users.list?token=%s", cfg.SlackApiToken)
-    resp, err := client.Get(url)
+// syncDirectory fetches the full, paginated member list from Slack, filters
+// and sorts it, then swaps it into the cache. It's safe to call repeatedly
+// from a background goroutine.
+func syncDirectory(ctx context.Context) {
+    providers, err := buildProviders(cfg)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        log.Printf("facelist: sync failed: %v\n", err)
+        directory.recordError(err)
         return
     }
-    body, _ := ioutil.ReadAll(resp.Body)
 
-    err = json.Unmarshal(body, &userlist)
-    if err != nil {
-        log.Fatal(err)
+    members, errs := fetchAll(ctx, providers)
+    for _, err := range errs {
+        log.Printf("facelist: provider failed: %v\n", err)
+    }
+    if len(members) == 0 && len(errs) > 0 {
+        directory.recordError(errs[0])
+        return
     }
 
     // Filter out deleted accounts, bots and users without @tink.se email adresses
     filteredUsers := []User{}
-    for i := range userlist.Members {
-        user := userlist.Members[i]
+    for i := range members {
+        user := members[i]
         if !user.Deleted && !user.IsBot && strings.HasSuffix(user.Profile.Email, cfg.EmailFilter) {
             filteredUsers = append(filteredUsers, user)
         }
@@ -205,14 +415,108 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
         return strings.ToLower(filteredUsers[i].Profile.RealName) < strings.ToLower(filteredUsers[j].Profile.RealName)
     })
 
-    userlist.Members = filteredUsers
+    var fieldLabels map[string]string
+    if labels, err := fetchSlackProfileFieldLabels(urlfetch.Client(ctx), cfg.SlackApiToken); err != nil {
+        log.Printf("facelist: failed to fetch profile field labels: %v\n", err)
+    } else {
+        fieldLabels = labels
+    }
+
+    if _, errs := processDirectoryChanges(ctx, eventStore, eventSinks, filteredUsers, time.Now()); len(errs) > 0 {
+        for _, err := range errs {
+            log.Printf("facelist: event processing error: %v\n", err)
+        }
+    }
+
+    directory.set(UserList{SlackTeam: cfg.SlackTeam, Members: filteredUsers}, fieldLabels)
+}
+
+// startSyncLoop runs syncDirectory immediately and then on every tick of
+// cfg.SyncInterval, until ctx is done.
+func startSyncLoop(ctx context.Context) {
+    syncDirectory(ctx)
+    ticker := time.NewTicker(cfg.SyncInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            syncDirectory(ctx)
+        }
+    }
+}
+
+func (c *directoryCache) set(ul UserList, fieldLabels map[string]string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.userlist = ul
+    c.fieldLabels = fieldLabels
+    c.lastSyncTime = time.Now()
+    c.lastSyncErr = nil
+}
+
+func (c *directoryCache) recordError(err error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.lastSyncErr = err
+}
+
+func (c *directoryCache) snapshot() (UserList, time.Time, error) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.userlist, c.lastSyncTime, c.lastSyncErr
+}
+
+func (c *directoryCache) profileFieldLabels() map[string]string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.fieldLabels
+}
+
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+    userlist, _, _ := directory.snapshot()
     if err := IndexTemplate.Execute(w, userlist); err != nil {
         log.Printf("Failed to execute index template: %v\n", err)
         http.Error(w, "Oops. That's embarrassing. Please try again later.", http.StatusInternalServerError)
     }
 }
 
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+    userlist, lastSyncTime, lastSyncErr := directory.snapshot()
+
+    status := struct {
+        Ok           bool      `json:"ok"`
+        LastSyncTime time.Time `json:"last_sync_time"`
+        LastSyncErr  string    `json:"last_sync_error,omitempty"`
+        MemberCount  int       `json:"member_count"`
+    }{
+        Ok:           lastSyncErr == nil && !lastSyncTime.IsZero(),
+        LastSyncTime: lastSyncTime,
+        MemberCount:  len(userlist.Members),
+    }
+    if lastSyncErr != nil {
+        status.LastSyncErr = lastSyncErr.Error()
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if !status.Ok {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    if err := json.NewEncoder(w).Encode(status); err != nil {
+        log.Printf("Failed to encode healthz response: %v\n", err)
+    }
+}
+
 func main() {
-    http.HandleFunc("/", indexHandler)
+    go startSyncLoop(appengine.BackgroundContext())
+    http.HandleFunc("/", requireAuth(indexHandler))
+    http.HandleFunc("/api/members", requireAuth(membersHandler))
+    http.HandleFunc("/api/teams", requireAuth(teamsHandler))
+    http.HandleFunc("/api/profile-fields", requireAuth(profileFieldsHandler))
+    http.HandleFunc("/api/events", requireSessionOrEventsToken(eventsHandler))
+    http.HandleFunc("/oauth/login", oauthLoginHandler)
+    http.HandleFunc("/oauth/callback", oauthCallbackHandler)
+    http.HandleFunc("/healthz", healthzHandler)
     appengine.Main()
 }
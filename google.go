@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "google.golang.org/appengine/urlfetch"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+)
+
+const googleDirectoryUsersURL = "https://admin.googleapis.com/admin/directory/v1/users"
+
+// GoogleProvider fetches members from the Google Workspace Directory API.
+// APIToken is a bearer token for a service account with domain-wide
+// delegation and the directory.user.readonly scope.
+type GoogleProvider struct {
+    APIToken string
+    Domain   string
+}
+
+type googleUsersListResponse struct {
+    Users         []googleUser `json:"users"`
+    NextPageToken string       `json:"nextPageToken"`
+}
+
+type googleUser struct {
+    PrimaryEmail string `json:"primaryEmail"`
+    Name         struct {
+        FullName  string `json:"fullName"`
+        GivenName string `json:"givenName"`
+    } `json:"name"`
+    ThumbnailPhotoURL string `json:"thumbnailPhotoUrl"`
+    Organizations     []struct {
+        Title string `json:"title"`
+    } `json:"organizations"`
+}
+
+func (p *GoogleProvider) FetchMembers(ctx context.Context) ([]User, error) {
+    client := urlfetch.Client(ctx)
+    var users []User
+    pageToken := ""
+
+    for {
+        q := url.Values{}
+        q.Set("domain", p.Domain)
+        q.Set("maxResults", "500")
+        if pageToken != "" {
+            q.Set("pageToken", pageToken)
+        }
+
+        req, err := http.NewRequest("GET", googleDirectoryUsersURL+"?"+q.Encode(), nil)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("Authorization", "Bearer "+p.APIToken)
+
+        resp, err := client.Do(req)
+        if err != nil {
+            return nil, err
+        }
+        body, err := ioutil.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            return nil, err
+        }
+        if resp.StatusCode != http.StatusOK {
+            return nil, fmt.Errorf("google: directory users list failed with status %d", resp.StatusCode)
+        }
+
+        var page googleUsersListResponse
+        if err := json.Unmarshal(body, &page); err != nil {
+            return nil, err
+        }
+
+        for _, gu := range page.Users {
+            users = append(users, googleUserToUser(gu))
+        }
+
+        if page.NextPageToken == "" {
+            break
+        }
+        pageToken = page.NextPageToken
+    }
+
+    return users, nil
+}
+
+func googleUserToUser(gu googleUser) User {
+    var title string
+    if len(gu.Organizations) > 0 {
+        title = gu.Organizations[0].Title
+    }
+    return User{
+        Id:   gu.PrimaryEmail,
+        Name: gu.Name.GivenName,
+        Profile: Profile{
+            FirstName: gu.Name.GivenName,
+            RealName:  gu.Name.FullName,
+            Title:     title,
+            Image192:  gu.ThumbnailPhotoURL,
+            Email:     gu.PrimaryEmail,
+        },
+    }
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "fmt"
+    "github.com/go-ldap/ldap/v3"
+)
+
+// LDAPProvider fetches members from an LDAP/AD directory. Mapping is:
+// cn -> RealName, givenName -> FirstName, sn -> LastName, mail -> Email,
+// title -> Title.
+//
+// LDAP is a raw TCP protocol, not HTTP, so unlike the other providers
+// this one dials out directly instead of going through urlfetch; it
+// needs a runtime that allows that (App Engine flexible or second-gen
+// standard), not the classic first-gen standard sandbox.
+type LDAPProvider struct {
+    URL      string
+    BindDN   string
+    BindPass string
+    BaseDN   string
+    Filter   string
+}
+
+var ldapAttributes = []string{"cn", "givenName", "sn", "mail", "title"}
+
+func (p *LDAPProvider) FetchMembers(ctx context.Context) ([]User, error) {
+    conn, err := ldap.DialURL(p.URL)
+    if err != nil {
+        return nil, fmt.Errorf("ldap: dial %s: %w", p.URL, err)
+    }
+    defer conn.Close()
+
+    if p.BindDN != "" {
+        if err := conn.Bind(p.BindDN, p.BindPass); err != nil {
+            return nil, fmt.Errorf("ldap: bind as %s: %w", p.BindDN, err)
+        }
+    }
+
+    req := ldap.NewSearchRequest(
+        p.BaseDN,
+        ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+        p.Filter, ldapAttributes, nil,
+    )
+    result, err := conn.Search(req)
+    if err != nil {
+        return nil, fmt.Errorf("ldap: search %s: %w", p.BaseDN, err)
+    }
+
+    users := make([]User, 0, len(result.Entries))
+    for _, entry := range result.Entries {
+        users = append(users, User{
+            Id: entry.DN,
+            Profile: Profile{
+                RealName:  entry.GetAttributeValue("cn"),
+                FirstName: entry.GetAttributeValue("givenName"),
+                LastName:  entry.GetAttributeValue("sn"),
+                Email:     entry.GetAttributeValue("mail"),
+                Title:     entry.GetAttributeValue("title"),
+            },
+        })
+    }
+    return users, nil
+}
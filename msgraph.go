@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "google.golang.org/appengine/urlfetch"
+    "io/ioutil"
+    "net/http"
+)
+
+const msGraphUsersURL = "https://graph.microsoft.com/v1.0/users"
+
+// MSGraphProvider fetches members from Microsoft Graph's /users endpoint.
+// Fetching each user's photo requires a separate /users/{id}/photo/$value
+// call per user, so Profile.Image192 is left blank for this provider; the
+// aggregator will fill it in from another provider if one is configured.
+type MSGraphProvider struct {
+    APIToken string
+}
+
+type msGraphUsersResponse struct {
+    Value    []msGraphUser `json:"value"`
+    NextLink string        `json:"@odata.nextLink"`
+}
+
+type msGraphUser struct {
+    DisplayName string `json:"displayName"`
+    GivenName   string `json:"givenName"`
+    Surname     string `json:"surname"`
+    Mail        string `json:"mail"`
+    JobTitle    string `json:"jobTitle"`
+    Id          string `json:"id"`
+}
+
+func (p *MSGraphProvider) FetchMembers(ctx context.Context) ([]User, error) {
+    client := urlfetch.Client(ctx)
+    var users []User
+    nextURL := msGraphUsersURL
+
+    for nextURL != "" {
+        req, err := http.NewRequest("GET", nextURL, nil)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("Authorization", "Bearer "+p.APIToken)
+
+        resp, err := client.Do(req)
+        if err != nil {
+            return nil, err
+        }
+        body, err := ioutil.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            return nil, err
+        }
+        if resp.StatusCode != http.StatusOK {
+            return nil, fmt.Errorf("msgraph: users list failed with status %d", resp.StatusCode)
+        }
+
+        var page msGraphUsersResponse
+        if err := json.Unmarshal(body, &page); err != nil {
+            return nil, err
+        }
+
+        for _, gu := range page.Value {
+            users = append(users, msGraphUserToUser(gu))
+        }
+        nextURL = page.NextLink
+    }
+
+    return users, nil
+}
+
+func msGraphUserToUser(gu msGraphUser) User {
+    return User{
+        Id:   gu.Id,
+        Name: gu.GivenName,
+        Profile: Profile{
+            FirstName: gu.GivenName,
+            LastName:  gu.Surname,
+            RealName:  gu.DisplayName,
+            Title:     gu.JobTitle,
+            Email:     gu.Mail,
+        },
+    }
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "fmt"
+    "reflect"
+    "strings"
+    "sync"
+)
+
+// DirectoryProvider is a source of directory members. Slack is the
+// original and still the default, but facelist can be pointed at any
+// number of providers at once; their results are merged by email.
+type DirectoryProvider interface {
+    FetchMembers(ctx context.Context) ([]User, error)
+}
+
+// hasProvider reports whether name is among cfg.Providers, using the same
+// case/whitespace normalization as buildProviders.
+func hasProvider(cfg config, name string) bool {
+    for _, p := range cfg.Providers {
+        if strings.ToLower(strings.TrimSpace(p)) == name {
+            return true
+        }
+    }
+    return false
+}
+
+// buildProviders turns the configured FACELIST_PROVIDERS names into
+// DirectoryProvider instances.
+func buildProviders(cfg config) ([]DirectoryProvider, error) {
+    var providers []DirectoryProvider
+    for _, name := range cfg.Providers {
+        switch strings.ToLower(strings.TrimSpace(name)) {
+        case "slack":
+            providers = append(providers, &SlackProvider{APIToken: cfg.SlackApiToken})
+        case "google":
+            providers = append(providers, &GoogleProvider{
+                APIToken: cfg.GoogleAPIToken,
+                Domain:   cfg.GoogleDomain,
+            })
+        case "msgraph", "microsoft":
+            providers = append(providers, &MSGraphProvider{APIToken: cfg.MSGraphAPIToken})
+        case "ldap":
+            providers = append(providers, &LDAPProvider{
+                URL:      cfg.LDAPURL,
+                BindDN:   cfg.LDAPBindDN,
+                BindPass: cfg.LDAPBindPass,
+                BaseDN:   cfg.LDAPBaseDN,
+                Filter:   cfg.LDAPFilter,
+            })
+        default:
+            return nil, fmt.Errorf("facelist: unknown provider %q", name)
+        }
+    }
+    return providers, nil
+}
+
+// fetchAll runs every provider concurrently and merges the members they
+// return by email, preferring the richest profile when more than one
+// provider knows about the same person. A single provider failing is
+// logged by the caller via the returned per-provider errors; it does not
+// prevent the other providers' members from being merged in.
+func fetchAll(ctx context.Context, providers []DirectoryProvider) ([]User, []error) {
+    type result struct {
+        members []User
+        err     error
+    }
+    results := make([]result, len(providers))
+
+    var wg sync.WaitGroup
+    for i, p := range providers {
+        wg.Add(1)
+        go func(i int, p DirectoryProvider) {
+            defer wg.Done()
+            members, err := p.FetchMembers(ctx)
+            results[i] = result{members: members, err: err}
+        }(i, p)
+    }
+    wg.Wait()
+
+    var errs []error
+    merged := map[string]User{}
+    var order []string
+    for _, r := range results {
+        if r.err != nil {
+            errs = append(errs, r.err)
+            continue
+        }
+        for _, u := range r.members {
+            key := strings.ToLower(u.Profile.Email)
+            if key == "" {
+                key = u.Id
+            }
+            if existing, ok := merged[key]; ok {
+                merged[key] = mergeUsers(existing, u)
+                continue
+            }
+            merged[key] = u
+            order = append(order, key)
+        }
+    }
+
+    users := make([]User, 0, len(order))
+    for _, key := range order {
+        users = append(users, merged[key])
+    }
+    return users, errs
+}
+
+// mergeUsers fills any blank field on a's Profile with the corresponding
+// value from b's, so that a richer profile from one provider wins over a
+// sparser one from another without discarding fields neither provider
+// shares. It walks the struct via reflection rather than listing fields
+// by name, so it can't silently stop covering a field the next time
+// Profile grows.
+func mergeUsers(a, b User) User {
+    mergeProfile(&a.Profile, b.Profile)
+    return a
+}
+
+func mergeProfile(a *Profile, b Profile) {
+    av := reflect.ValueOf(a).Elem()
+    bv := reflect.ValueOf(b)
+    t := av.Type()
+
+    for i := 0; i < t.NumField(); i++ {
+        if t.Field(i).Name == "Fields" {
+            continue
+        }
+        field := av.Field(i)
+        if field.IsZero() {
+            field.Set(bv.Field(i))
+        }
+    }
+
+    for id, f := range b.Fields {
+        if _, ok := a.Fields[id]; ok {
+            continue
+        }
+        if a.Fields == nil {
+            a.Fields = map[string]CustomField{}
+        }
+        a.Fields[id] = f
+    }
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestMergeProfileFillsBlankFields(t *testing.T) {
+    a := Profile{RealName: "Ada Lovelace", Email: "ada@example.com"}
+    b := Profile{RealName: "Ada L.", Title: "Engineer", Phone: "555-1234"}
+
+    mergeProfile(&a, b)
+
+    if a.RealName != "Ada Lovelace" {
+        t.Errorf("RealName should be kept from a, got %q", a.RealName)
+    }
+    if a.Title != "Engineer" {
+        t.Errorf("Title should be filled in from b, got %q", a.Title)
+    }
+    if a.Phone != "555-1234" {
+        t.Errorf("Phone should be filled in from b, got %q", a.Phone)
+    }
+}
+
+func TestMergeProfileMergesCustomFields(t *testing.T) {
+    a := Profile{Fields: map[string]CustomField{"Xf1": {Value: "Platform"}}}
+    b := Profile{Fields: map[string]CustomField{
+        "Xf1": {Value: "Ignored, a already has this field"},
+        "Xf2": {Value: "Added from b"},
+    }}
+
+    mergeProfile(&a, b)
+
+    if a.Fields["Xf1"].Value != "Platform" {
+        t.Errorf("existing field Xf1 should not be overwritten, got %q", a.Fields["Xf1"].Value)
+    }
+    if a.Fields["Xf2"].Value != "Added from b" {
+        t.Errorf("missing field Xf2 should be added from b, got %q", a.Fields["Xf2"].Value)
+    }
+}
+
+func TestHasProvider(t *testing.T) {
+    cfg := config{Providers: []string{" Slack ", "Google"}}
+    if !hasProvider(cfg, "slack") {
+        t.Error("expected hasProvider to match case- and whitespace-insensitively")
+    }
+    if hasProvider(cfg, "ldap") {
+        t.Error("expected hasProvider to report false for an unconfigured provider")
+    }
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "google.golang.org/appengine/urlfetch"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+)
+
+// EventSink delivers a single directory change event to an integration.
+type EventSink interface {
+    Send(ctx context.Context, event Event) error
+}
+
+// eventMessage renders a human-readable summary of an event, shared by
+// both Slack sinks.
+func eventMessage(event Event) string {
+    switch event.Type {
+    case EventUserJoined:
+        return fmt.Sprintf(":wave: %s joined", event.Name)
+    case EventUserLeft:
+        return fmt.Sprintf(":door: %s left", event.Name)
+    case EventUserTitleChanged:
+        return fmt.Sprintf(":briefcase: %s's title changed from %q to %q", event.Name, event.OldValue, event.NewValue)
+    case EventUserPhotoChanged:
+        return fmt.Sprintf(":camera: %s updated their photo", event.Name)
+    default:
+        return fmt.Sprintf("%s: %s", event.Type, event.Name)
+    }
+}
+
+// SlackWebhookSink posts events to a Slack incoming webhook.
+type SlackWebhookSink struct {
+    URL       string
+    Username  string
+    IconEmoji string
+}
+
+func (s *SlackWebhookSink) Send(ctx context.Context, event Event) error {
+    payload := struct {
+        Text      string `json:"text"`
+        Username  string `json:"username,omitempty"`
+        IconEmoji string `json:"icon_emoji,omitempty"`
+    }{
+        Text:      eventMessage(event),
+        Username:  s.Username,
+        IconEmoji: s.IconEmoji,
+    }
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+
+    resp, err := urlfetch.Client(ctx).Post(s.URL, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("slack webhook: unexpected status %d", resp.StatusCode)
+    }
+    return nil
+}
+
+// HTTPSWebhookSink POSTs the event as JSON to a generic HTTPS endpoint,
+// signing the body with HMAC-SHA256 so the receiver can verify it came
+// from facelist.
+type HTTPSWebhookSink struct {
+    URL    string
+    Secret string
+}
+
+func (s *HTTPSWebhookSink) Send(ctx context.Context, event Event) error {
+    body, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Facelist-Signature", signPayload(s.Secret, body))
+
+    resp, err := urlfetch.Client(ctx).Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+    }
+    return nil
+}
+
+func signPayload(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+const slackChatPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// SlackChatSink posts events into a Slack channel via chat.postMessage,
+// rather than a static incoming webhook.
+type SlackChatSink struct {
+    APIToken string
+    Channel  string
+}
+
+type slackChatPostMessageResponse struct {
+    Ok    bool   `json:"ok"`
+    Error string `json:"error"`
+}
+
+func (s *SlackChatSink) Send(ctx context.Context, event Event) error {
+    form := url.Values{}
+    form.Set("token", s.APIToken)
+    form.Set("channel", s.Channel)
+    form.Set("text", eventMessage(event))
+
+    resp, err := urlfetch.Client(ctx).PostForm(slackChatPostMessageURL, form)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+
+    var result slackChatPostMessageResponse
+    if err := json.Unmarshal(body, &result); err != nil {
+        return err
+    }
+    if !result.Ok {
+        return fmt.Errorf("slack: chat.postMessage failed: %s", result.Error)
+    }
+    return nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "google.golang.org/appengine/urlfetch"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+// SlackProvider fetches the workspace member list from Slack's users.list.
+type SlackProvider struct {
+    APIToken string
+}
+
+func (p *SlackProvider) FetchMembers(ctx context.Context) ([]User, error) {
+    client := urlfetch.Client(ctx)
+    return fetchSlackUsers(ctx, client, p.APIToken)
+}
+
+const slackTeamProfileURL = "https://slack.com/api/team.profile.get"
+
+type slackTeamProfileResponse struct {
+    Ok      bool   `json:"ok"`
+    Error   string `json:"error"`
+    Profile struct {
+        Fields []struct {
+            Id    string `json:"id"`
+            Label string `json:"label"`
+        } `json:"fields"`
+    } `json:"profile"`
+}
+
+// fetchSlackProfileFieldLabels returns the team's custom profile field
+// definitions (id -> label), so the UI can show "Department" instead of
+// a raw field ID like "Xf0A1B2C3D".
+func fetchSlackProfileFieldLabels(client *http.Client, token string) (map[string]string, error) {
+    resp, err := client.Get(slackTeamProfileURL + "?token=" + url.QueryEscape(token))
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    var page slackTeamProfileResponse
+    if err := json.Unmarshal(body, &page); err != nil {
+        return nil, err
+    }
+    if !page.Ok {
+        return nil, fmt.Errorf("slack: team.profile.get failed: %s", page.Error)
+    }
+
+    labels := make(map[string]string, len(page.Profile.Fields))
+    for _, f := range page.Profile.Fields {
+        labels[f.Id] = f.Label
+    }
+    return labels, nil
+}
+
+const (
+    slackUsersListURL = "https://slack.com/api/users.list"
+    slackPageLimit    = 200
+    slackMaxBackoff   = 30 * time.Second
+)
+
+type slackUsersListResponse struct {
+    Ok               bool   `json:"ok"`
+    Error            string `json:"error"`
+    Members          []User `json:"members"`
+    ResponseMetadata struct {
+        NextCursor string `json:"next_cursor"`
+    } `json:"response_metadata"`
+}
+
+// fetchSlackUsers walks every page of users.list, following the cursor in
+// response_metadata until Slack reports no more pages. Whenever Slack
+// answers with a 429, it waits the Retry-After Slack sent; only when
+// Slack doesn't send one does it fall back to its own exponential
+// backoff, which grows only on those unannounced waits.
+func fetchSlackUsers(ctx context.Context, client *http.Client, token string) ([]User, error) {
+    var members []User
+    cursor := ""
+    backoff := time.Second
+
+    for {
+        select {
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        default:
+        }
+
+        page, err := fetchSlackUsersPage(client, token, cursor)
+        if rl, ok := err.(*rateLimitedError); ok {
+            wait := rl.retryAfter
+            if !rl.hasRetryAfter {
+                wait = backoff
+                backoff *= 2
+                if backoff > slackMaxBackoff {
+                    backoff = slackMaxBackoff
+                }
+            }
+            log.Printf("slack: rate limited, waiting %s", wait)
+            time.Sleep(wait)
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        backoff = time.Second
+
+        members = append(members, page.Members...)
+        if page.ResponseMetadata.NextCursor == "" {
+            break
+        }
+        cursor = page.ResponseMetadata.NextCursor
+    }
+
+    return members, nil
+}
+
+// rateLimitedError signals a 429 from Slack. retryAfter is only
+// meaningful when hasRetryAfter is true; otherwise the caller should
+// fall back to its own backoff.
+type rateLimitedError struct {
+    retryAfter    time.Duration
+    hasRetryAfter bool
+}
+
+func (e *rateLimitedError) Error() string { return "slack: rate limited" }
+
+func fetchSlackUsersPage(client *http.Client, token, cursor string) (*slackUsersListResponse, error) {
+    q := url.Values{}
+    q.Set("token", token)
+    q.Set("limit", strconv.Itoa(slackPageLimit))
+    if cursor != "" {
+        q.Set("cursor", cursor)
+    }
+
+    resp, err := client.Get(slackUsersListURL + "?" + q.Encode())
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusTooManyRequests {
+        wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"))
+        return nil, &rateLimitedError{retryAfter: wait, hasRetryAfter: ok}
+    }
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    var page slackUsersListResponse
+    if err := json.Unmarshal(body, &page); err != nil {
+        return nil, err
+    }
+    if !page.Ok {
+        return nil, fmt.Errorf("slack: users.list failed: %s", page.Error)
+    }
+
+    return &page, nil
+}
+
+func parseRetryAfter(header string) (time.Duration, bool) {
+    if header == "" {
+        return 0, false
+    }
+    secs, err := strconv.Atoi(header)
+    if err != nil {
+        return 0, false
+    }
+    return time.Duration(secs) * time.Second, true
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2018 Tink AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "google.golang.org/appengine/datastore"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Store persists the last-seen directory snapshot and the change events
+// derived from it, so they survive instance restarts.
+type Store interface {
+    // LastSnapshot returns the most recently saved snapshot. found is
+    // false when no snapshot has ever been saved (first sync after
+    // deploy, or any sync after a restart with EVENT_STORE=memory), as
+    // opposed to a snapshot that was saved but legitimately empty.
+    LastSnapshot(ctx context.Context) (users []User, found bool, err error)
+    SaveSnapshot(ctx context.Context, users []User) error
+    AppendEvents(ctx context.Context, events []Event) error
+    Events(ctx context.Context, since time.Time) ([]Event, error)
+}
+
+// newStore picks a Store implementation from EVENT_STORE. "datastore" is
+// the durable default for App Engine deployments; "memory" is for local
+// development and keeps no state across restarts.
+func newStore(cfg config) Store {
+    if cfg.EventStore == "datastore" {
+        return &datastoreStore{}
+    }
+    return newMemoryStore()
+}
+
+// memoryStore is a Store backed by an in-process map. It's lost on
+// restart, so it's meant for local development, not production.
+type memoryStore struct {
+    mu       sync.RWMutex
+    snapshot []User
+    seeded   bool
+    events   []Event
+}
+
+func newMemoryStore() *memoryStore {
+    return &memoryStore{}
+}
+
+func (s *memoryStore) LastSnapshot(ctx context.Context) ([]User, bool, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.snapshot, s.seeded, nil
+}
+
+func (s *memoryStore) SaveSnapshot(ctx context.Context, users []User) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.snapshot = users
+    s.seeded = true
+    return nil
+}
+
+func (s *memoryStore) AppendEvents(ctx context.Context, events []Event) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.events = append(s.events, events...)
+    return nil
+}
+
+func (s *memoryStore) Events(ctx context.Context, since time.Time) ([]Event, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    var matching []Event
+    for _, e := range s.events {
+        if e.Timestamp.After(since) {
+            matching = append(matching, e)
+        }
+    }
+    sort.Slice(matching, func(i, j int) bool {
+        return matching[i].Timestamp.Before(matching[j].Timestamp)
+    })
+    return matching, nil
+}
+
+// datastoreStore is a Store backed by Cloud Datastore, so the last-seen
+// snapshot and event history survive instance restarts and deploys.
+type datastoreStore struct{}
+
+type datastoreSnapshot struct {
+    Users []byte `datastore:",noindex"`
+}
+
+type datastoreEvent struct {
+    Type      string
+    Timestamp time.Time
+    UserID    string
+    Email     string
+    Name      string
+    OldValue  string `datastore:",noindex"`
+    NewValue  string `datastore:",noindex"`
+}
+
+func (s *datastoreStore) snapshotKey(ctx context.Context) *datastore.Key {
+    return datastore.NewKey(ctx, "FacelistSnapshot", "current", 0, nil)
+}
+
+func (s *datastoreStore) LastSnapshot(ctx context.Context) ([]User, bool, error) {
+    var entity datastoreSnapshot
+    if err := datastore.Get(ctx, s.snapshotKey(ctx), &entity); err == datastore.ErrNoSuchEntity {
+        return nil, false, nil
+    } else if err != nil {
+        return nil, false, err
+    }
+
+    var users []User
+    if err := json.Unmarshal(entity.Users, &users); err != nil {
+        return nil, false, err
+    }
+    return users, true, nil
+}
+
+func (s *datastoreStore) SaveSnapshot(ctx context.Context, users []User) error {
+    data, err := json.Marshal(users)
+    if err != nil {
+        return err
+    }
+    _, err = datastore.Put(ctx, s.snapshotKey(ctx), &datastoreSnapshot{Users: data})
+    return err
+}
+
+func (s *datastoreStore) AppendEvents(ctx context.Context, events []Event) error {
+    keys := make([]*datastore.Key, len(events))
+    entities := make([]*datastoreEvent, len(events))
+    for i, e := range events {
+        keys[i] = datastore.NewIncompleteKey(ctx, "FacelistEvent", nil)
+        entities[i] = &datastoreEvent{
+            Type:      string(e.Type),
+            Timestamp: e.Timestamp,
+            UserID:    e.UserID,
+            Email:     e.Email,
+            Name:      e.Name,
+            OldValue:  e.OldValue,
+            NewValue:  e.NewValue,
+        }
+    }
+    _, err := datastore.PutMulti(ctx, keys, entities)
+    return err
+}
+
+func (s *datastoreStore) Events(ctx context.Context, since time.Time) ([]Event, error) {
+    q := datastore.NewQuery("FacelistEvent").
+        Filter("Timestamp >", since).
+        Order("Timestamp")
+
+    var entities []datastoreEvent
+    if _, err := q.GetAll(ctx, &entities); err != nil {
+        return nil, err
+    }
+
+    events := make([]Event, len(entities))
+    for i, e := range entities {
+        events[i] = Event{
+            Type:      EventType(e.Type),
+            Timestamp: e.Timestamp,
+            UserID:    e.UserID,
+            Email:     e.Email,
+            Name:      e.Name,
+            OldValue:  e.OldValue,
+            NewValue:  e.NewValue,
+        }
+    }
+    return events, nil
+}